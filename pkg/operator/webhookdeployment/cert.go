@@ -0,0 +1,273 @@
+package webhookdeployment
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceapply"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
+)
+
+const (
+	// signingSecretName holds the self-signed CA used to issue the webhook's
+	// serving certificate. It never leaves the operator namespace.
+	signingSecretName = "csi-snapshot-webhook-signer"
+	// servingSecretName holds the serving certificate mounted into the
+	// webhook Deployment and whose CA is projected into the
+	// ValidatingWebhookConfiguration.
+	servingSecretName = "csi-snapshot-webhook-serving-cert"
+
+	// webhookServiceName is the name of the Service fronting the webhook
+	// Deployment; it is used to compute the serving certificate's DNS SANs.
+	webhookServiceName = "csi-snapshot-webhook"
+
+	certValidity          = 2 * 365 * 24 * time.Hour
+	certRotationThreshold = 30 * 24 * time.Hour
+)
+
+// webhookCertManager owns the lifecycle of the CA and serving certificate
+// used by the validating webhook. It creates both on first sync and rotates
+// them once they come within certRotationThreshold of expiring.
+type webhookCertManager struct {
+	kubeClient      kubernetes.Interface
+	secretLister    corelistersv1.SecretLister
+	eventRecorder   events.Recorder
+	targetNamespace string
+}
+
+func newWebhookCertManager(kubeClient kubernetes.Interface, secretLister corelistersv1.SecretLister, eventRecorder events.Recorder, targetNamespace string) *webhookCertManager {
+	return &webhookCertManager{
+		kubeClient:      kubeClient,
+		secretLister:    secretLister,
+		eventRecorder:   eventRecorder,
+		targetNamespace: targetNamespace,
+	}
+}
+
+// ensureCerts makes sure the signing CA and the serving certificate it issued
+// both exist and are not close to expiry, (re)generating whichever one is
+// missing, invalid or expiring soon. It returns the PEM-encoded CA bundle to
+// project into the webhook config and whether anything was (re)generated,
+// so the caller can decide to re-enqueue a follow-up sync.
+func (m *webhookCertManager) ensureCerts() (caBundle []byte, rotated bool, err error) {
+	signingCert, signingKey, signingRotated, err := m.ensureSigningCert()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to ensure webhook signing certificate: %w", err)
+	}
+
+	servingRotated, err := m.ensureServingCert(signingCert, signingKey, signingRotated)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to ensure webhook serving certificate: %w", err)
+	}
+
+	return encodeCertPEM(signingCert), signingRotated || servingRotated, nil
+}
+
+func (m *webhookCertManager) ensureSigningCert() (*x509.Certificate, *rsa.PrivateKey, bool, error) {
+	secret, err := m.secretLister.Secrets(m.targetNamespace).Get(signingSecretName)
+	if err == nil {
+		if cert, key, parseErr := parseCertKeySecret(secret); parseErr == nil && !needsRotation(cert) {
+			return cert, key, false, nil
+		}
+	} else if !apierrors.IsNotFound(err) {
+		return nil, nil, false, err
+	}
+
+	cert, key, err := newSelfSignedCA(fmt.Sprintf("%s CA", webhookServiceName))
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if err := m.applyCertKeySecret(signingSecretName, cert, key); err != nil {
+		return nil, nil, false, err
+	}
+	return cert, key, true, nil
+}
+
+func (m *webhookCertManager) ensureServingCert(signingCert *x509.Certificate, signingKey *rsa.PrivateKey, forceRotate bool) (bool, error) {
+	dnsNames := webhookServiceDNSNames(m.targetNamespace)
+
+	if !forceRotate {
+		secret, err := m.secretLister.Secrets(m.targetNamespace).Get(servingSecretName)
+		if err == nil {
+			if cert, _, parseErr := parseCertKeySecret(secret); parseErr == nil && !needsRotation(cert) && signedBy(cert, signingCert) {
+				return false, nil
+			}
+		} else if !apierrors.IsNotFound(err) {
+			return false, err
+		}
+	}
+
+	cert, key, err := newSignedServingCert(signingCert, signingKey, dnsNames)
+	if err != nil {
+		return false, err
+	}
+
+	if err := m.applyCertKeySecret(servingSecretName, cert, key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// applyCertKeySecret uses resourceapply.ApplySecret like the rest of this
+// package applies every other resource kind, instead of hand-rolling
+// Get/Create/Update: a naive Update with a freshly-built Secret would carry
+// no ResourceVersion and fail against a real API server on every rotation
+// past the first one.
+func (m *webhookCertManager) applyCertKeySecret(name string, cert *x509.Certificate, key *rsa.PrivateKey) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: m.targetNamespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       encodeCertPEM(cert),
+			corev1.TLSPrivateKeyKey: encodeKeyPEM(key),
+		},
+	}
+
+	_, _, err := resourceapply.ApplySecret(m.kubeClient.CoreV1(), m.eventRecorder, secret)
+	return err
+}
+
+// needsRotation reports whether cert is already expired or will expire
+// within certRotationThreshold, in which case it must be regenerated before
+// the webhook is left serving with an invalid certificate.
+func needsRotation(cert *x509.Certificate) bool {
+	return time.Now().Add(certRotationThreshold).After(cert.NotAfter)
+}
+
+func signedBy(cert, signingCert *x509.Certificate) bool {
+	return cert.CheckSignatureFrom(signingCert) == nil
+}
+
+func webhookServiceDNSNames(namespace string) []string {
+	return []string{
+		webhookServiceName,
+		fmt.Sprintf("%s.%s", webhookServiceName, namespace),
+		fmt.Sprintf("%s.%s.svc", webhookServiceName, namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", webhookServiceName, namespace),
+	}
+}
+
+func newSelfSignedCA(commonName string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(certValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func newSignedServingCert(signingCert *x509.Certificate, signingKey *rsa.PrivateKey, dnsNames []string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signingCert, &key.PublicKey, signingKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func parseCertKeySecret(secret *corev1.Secret) (*x509.Certificate, *rsa.PrivateKey, error) {
+	certPEM, ok := secret.Data[corev1.TLSCertKey]
+	if !ok {
+		return nil, nil, fmt.Errorf("secret %s/%s has no %s", secret.Namespace, secret.Name, corev1.TLSCertKey)
+	}
+	keyPEM, ok := secret.Data[corev1.TLSPrivateKeyKey]
+	if !ok {
+		return nil, nil, fmt.Errorf("secret %s/%s has no %s", secret.Namespace, secret.Name, corev1.TLSPrivateKeyKey)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("secret %s/%s has invalid %s", secret.Namespace, secret.Name, corev1.TLSCertKey)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("secret %s/%s has invalid %s", secret.Namespace, secret.Name, corev1.TLSPrivateKeyKey)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, key, nil
+}
+
+func encodeCertPEM(cert *x509.Certificate) []byte {
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	return buf.Bytes()
+}
+
+func encodeKeyPEM(key *rsa.PrivateKey) []byte {
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return buf.Bytes()
+}
@@ -0,0 +1,107 @@
+package webhookdeployment
+
+import (
+	"encoding/json"
+	"fmt"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	operatorapi "github.com/openshift/api/operator/v1"
+)
+
+// unsupportedConfigOverrides is parsed out of OperatorSpec.UnsupportedConfigOverrides.
+// It only carries fields that are not (yet) part of the formal operator API and
+// that operators can use to opt out of default behaviour in unusual clusters.
+type unsupportedConfigOverrides struct {
+	MutatingWebhook struct {
+		Disabled bool `json:"disabled"`
+	} `json:"mutatingWebhook"`
+	Webhook webhookOverrides `json:"webhook"`
+}
+
+// webhookOverrides lets cluster admins tune the generated Webhooks[] entries,
+// e.g. to exclude a tenant namespace or switch to Ignore during an upgrade.
+// Unset fields keep whatever the baked asset already has.
+type webhookOverrides struct {
+	FailurePolicy           *string               `json:"failurePolicy,omitempty"`
+	NamespaceSelector       *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	ObjectSelector          *metav1.LabelSelector `json:"objectSelector,omitempty"`
+	TimeoutSeconds          *int32                `json:"timeoutSeconds,omitempty"`
+	AdmissionReviewVersions []string              `json:"admissionReviewVersions,omitempty"`
+	// Replicas overrides the default replica target (see replicaTarget),
+	// still capped at the number of schedulable, Ready nodes available.
+	Replicas *int32 `json:"replicas,omitempty"`
+}
+
+// mutatingWebhookDisabled reports whether the operator CR asked the
+// MutatingWebhookConfiguration not to be deployed, e.g. because the cluster
+// already defaults VolumeSnapshotClass some other way.
+func mutatingWebhookDisabled(opSpec *operatorapi.OperatorSpec) (bool, error) {
+	if len(opSpec.UnsupportedConfigOverrides.Raw) == 0 {
+		return false, nil
+	}
+
+	var overrides unsupportedConfigOverrides
+	if err := json.Unmarshal(opSpec.UnsupportedConfigOverrides.Raw, &overrides); err != nil {
+		return false, err
+	}
+	return overrides.MutatingWebhook.Disabled, nil
+}
+
+// webhookOverridesFromSpec parses the per-webhook overrides out of the
+// operator CR. An empty/absent override section is not an error.
+func webhookOverridesFromSpec(opSpec *operatorapi.OperatorSpec) (webhookOverrides, error) {
+	if len(opSpec.UnsupportedConfigOverrides.Raw) == 0 {
+		return webhookOverrides{}, nil
+	}
+
+	var overrides unsupportedConfigOverrides
+	if err := json.Unmarshal(opSpec.UnsupportedConfigOverrides.Raw, &overrides); err != nil {
+		return webhookOverrides{}, err
+	}
+	return overrides.Webhook, nil
+}
+
+// applyWebhookOverrides mutates every entry of webhooks in place with the
+// fields set in o, validating selectors before anything is applied so a typo
+// in the operator CR is reported instead of silently dropping the webhook.
+func applyWebhookOverrides(webhooks []admissionv1.ValidatingWebhook, o webhookOverrides) error {
+	if o.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(o.NamespaceSelector); err != nil {
+			return fmt.Errorf("invalid namespaceSelector override: %w", err)
+		}
+	}
+	if o.ObjectSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(o.ObjectSelector); err != nil {
+			return fmt.Errorf("invalid objectSelector override: %w", err)
+		}
+	}
+	var failurePolicy *admissionv1.FailurePolicyType
+	if o.FailurePolicy != nil {
+		fp := admissionv1.FailurePolicyType(*o.FailurePolicy)
+		if fp != admissionv1.Fail && fp != admissionv1.Ignore {
+			return fmt.Errorf("invalid failurePolicy override %q: must be %q or %q", *o.FailurePolicy, admissionv1.Fail, admissionv1.Ignore)
+		}
+		failurePolicy = &fp
+	}
+
+	for i := range webhooks {
+		if failurePolicy != nil {
+			webhooks[i].FailurePolicy = failurePolicy
+		}
+		if o.NamespaceSelector != nil {
+			webhooks[i].NamespaceSelector = o.NamespaceSelector
+		}
+		if o.ObjectSelector != nil {
+			webhooks[i].ObjectSelector = o.ObjectSelector
+		}
+		if o.TimeoutSeconds != nil {
+			webhooks[i].TimeoutSeconds = o.TimeoutSeconds
+		}
+		if len(o.AdmissionReviewVersions) > 0 {
+			webhooks[i].AdmissionReviewVersions = o.AdmissionReviewVersions
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package webhookdeployment
+
+import (
+	"testing"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	operatorapi "github.com/openshift/api/operator/v1"
+)
+
+func strPtr(s string) *string { return &s }
+func int32Ptr(v int32) *int32 { return &v }
+
+func TestApplyWebhookOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides webhookOverrides
+		wantErr   bool
+		check     func(t *testing.T, webhooks []admissionv1.ValidatingWebhook)
+	}{
+		{
+			name:      "no overrides leaves the baked-in failurePolicy untouched",
+			overrides: webhookOverrides{},
+			check: func(t *testing.T, webhooks []admissionv1.ValidatingWebhook) {
+				if *webhooks[0].FailurePolicy != admissionv1.Ignore {
+					t.Errorf("expected baked-in Ignore to be preserved, got %v", *webhooks[0].FailurePolicy)
+				}
+			},
+		},
+		{
+			name:      "failurePolicy override applies to every webhook",
+			overrides: webhookOverrides{FailurePolicy: strPtr("Fail")},
+			check: func(t *testing.T, webhooks []admissionv1.ValidatingWebhook) {
+				for _, w := range webhooks {
+					if w.FailurePolicy == nil || *w.FailurePolicy != admissionv1.Fail {
+						t.Errorf("expected FailurePolicy Fail, got %v", w.FailurePolicy)
+					}
+				}
+			},
+		},
+		{
+			name:      "invalid failurePolicy is rejected",
+			overrides: webhookOverrides{FailurePolicy: strPtr("Retry")},
+			wantErr:   true,
+		},
+		{
+			name: "invalid namespaceSelector is rejected",
+			overrides: webhookOverrides{NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "foo", Operator: "Bogus"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "invalid objectSelector is rejected",
+			overrides: webhookOverrides{ObjectSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "foo", Operator: "Bogus"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name:      "timeoutSeconds and admissionReviewVersions are applied",
+			overrides: webhookOverrides{TimeoutSeconds: int32Ptr(5), AdmissionReviewVersions: []string{"v1"}},
+			check: func(t *testing.T, webhooks []admissionv1.ValidatingWebhook) {
+				for _, w := range webhooks {
+					if w.TimeoutSeconds == nil || *w.TimeoutSeconds != 5 {
+						t.Errorf("expected TimeoutSeconds 5, got %v", w.TimeoutSeconds)
+					}
+					if len(w.AdmissionReviewVersions) != 1 || w.AdmissionReviewVersions[0] != "v1" {
+						t.Errorf("expected AdmissionReviewVersions [v1], got %v", w.AdmissionReviewVersions)
+					}
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ignore := admissionv1.Ignore
+			webhooks := []admissionv1.ValidatingWebhook{
+				{Name: "a.example.com", FailurePolicy: &ignore},
+				{Name: "b.example.com", FailurePolicy: &ignore},
+			}
+
+			err := applyWebhookOverrides(webhooks, tt.overrides)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.check != nil {
+				tt.check(t, webhooks)
+			}
+		})
+	}
+}
+
+func TestWebhookOverridesFromSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr bool
+	}{
+		{name: "no overrides set"},
+		{name: "valid overrides", raw: `{"webhook":{"failurePolicy":"Ignore"}}`},
+		{name: "malformed json", raw: `{`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &operatorapi.OperatorSpec{}
+			if tt.raw != "" {
+				spec.UnsupportedConfigOverrides = runtime.RawExtension{Raw: []byte(tt.raw)}
+			}
+
+			_, err := webhookOverridesFromSpec(spec)
+			if tt.wantErr && err == nil {
+				t.Fatal("expected an error, got none")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestMutatingWebhookDisabled(t *testing.T) {
+	tests := []struct {
+		name         string
+		raw          string
+		wantDisabled bool
+		wantErr      bool
+	}{
+		{name: "no overrides set", wantDisabled: false},
+		{name: "explicitly enabled", raw: `{"mutatingWebhook":{"disabled":false}}`, wantDisabled: false},
+		{name: "explicitly disabled", raw: `{"mutatingWebhook":{"disabled":true}}`, wantDisabled: true},
+		{name: "malformed json", raw: `{`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &operatorapi.OperatorSpec{}
+			if tt.raw != "" {
+				spec.UnsupportedConfigOverrides = runtime.RawExtension{Raw: []byte(tt.raw)}
+			}
+
+			disabled, err := mutatingWebhookDisabled(spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if disabled != tt.wantDisabled {
+				t.Errorf("expected disabled=%v, got %v", tt.wantDisabled, disabled)
+			}
+		})
+	}
+}
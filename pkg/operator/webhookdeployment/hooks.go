@@ -0,0 +1,517 @@
+package webhookdeployment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/openshift/cluster-csi-snapshot-controller-operator/pkg/generated"
+	"github.com/openshift/cluster-csi-snapshot-controller-operator/pkg/operatorclient"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceapply"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceread"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	operatorapi "github.com/openshift/api/operator/v1"
+)
+
+// hookPhase identifies one of the four points in the webhook Deployment's
+// lifecycle at which extra assets (Jobs, ConfigMaps, ...) can be applied,
+// mirroring Helm's install/upgrade/uninstall hooks.
+type hookPhase string
+
+const (
+	hookPreInstall  hookPhase = "pre-install"
+	hookPostInstall hookPhase = "post-install"
+	hookPreDelete   hookPhase = "pre-delete"
+	hookPostDelete  hookPhase = "post-delete"
+
+	hookAnnotation             = "snapshot.operator.openshift.io/hook"
+	hookWeightAnnotation       = "snapshot.operator.openshift.io/hook-weight"
+	hookDeletePolicyAnnotation = "snapshot.operator.openshift.io/hook-delete-policy"
+
+	hookDeletePolicyBeforeCreation = "before-hook-creation"
+	hookDeletePolicySucceeded      = "hook-succeeded"
+	hookDeletePolicyFailed         = "hook-failed"
+
+	// hookAssetPrefix is the directory lifecycle-hook assets are baked
+	// under, so runHooks can tell them apart from the operator's own
+	// Deployment/webhook assets when walking generated.AssetNames().
+	hookAssetPrefix = "lifecycle-hooks/"
+
+	defaultPreInstallTimeout  = 60 * time.Second
+	defaultPostInstallTimeout = 600 * time.Second
+	defaultPreDeleteTimeout   = 60 * time.Second
+	defaultPostDeleteTimeout  = 600 * time.Second
+
+	hookPollInterval = 2 * time.Second
+)
+
+// hookTimeouts lets the operator CR tune how long to wait for a given
+// phase's Jobs to finish before giving up and going Degraded.
+type hookTimeouts struct {
+	PreInstallTimeoutSeconds  *int32 `json:"preInstallTimeoutSeconds,omitempty"`
+	PostInstallTimeoutSeconds *int32 `json:"postInstallTimeoutSeconds,omitempty"`
+	PreDeleteTimeoutSeconds   *int32 `json:"preDeleteTimeoutSeconds,omitempty"`
+	PostDeleteTimeoutSeconds  *int32 `json:"postDeleteTimeoutSeconds,omitempty"`
+}
+
+func (t hookTimeouts) forPhase(phase hookPhase) time.Duration {
+	switch phase {
+	case hookPreInstall:
+		if t.PreInstallTimeoutSeconds != nil {
+			return time.Duration(*t.PreInstallTimeoutSeconds) * time.Second
+		}
+		return defaultPreInstallTimeout
+	case hookPostInstall:
+		if t.PostInstallTimeoutSeconds != nil {
+			return time.Duration(*t.PostInstallTimeoutSeconds) * time.Second
+		}
+		return defaultPostInstallTimeout
+	case hookPreDelete:
+		if t.PreDeleteTimeoutSeconds != nil {
+			return time.Duration(*t.PreDeleteTimeoutSeconds) * time.Second
+		}
+		return defaultPreDeleteTimeout
+	case hookPostDelete:
+		if t.PostDeleteTimeoutSeconds != nil {
+			return time.Duration(*t.PostDeleteTimeoutSeconds) * time.Second
+		}
+		return defaultPostDeleteTimeout
+	default:
+		return defaultPreInstallTimeout
+	}
+}
+
+// hookError carries enough detail for the caller to set a Degraded
+// condition naming the failing hook, as opposed to a bare error string.
+type hookError struct {
+	hookName string
+	reason   string
+}
+
+func (e *hookError) Error() string {
+	return fmt.Sprintf("hook %s: %s", e.hookName, e.reason)
+}
+
+// runHooks applies every baked asset annotated for phase, in ascending
+// hook-weight order, checking each Job's status without blocking on it. It
+// returns done=true only once every hook for phase has reached Complete; as
+// soon as it finds one still running it stops, leaving later-weighted hooks
+// untouched, and returns done=false so the caller can re-check on a later
+// reconcile instead of blocking this goroutine on the Job. It is a no-op,
+// returning done=true, if no asset in the snapshot is annotated for phase.
+// It is a free function (not a method) so it can be reused both from
+// sync() and from the operator's uninstall path, which does not have a
+// running controller to call it on.
+func runHooks(ctx context.Context, client operatorclient.OperatorClient, kubeClient kubernetes.Interface, recorder events.Recorder, phase hookPhase, timeouts hookTimeouts) (bool, error) {
+	hooks, err := loadHooksForPhase(phase)
+	if err != nil {
+		return false, err
+	}
+
+	timeout := timeouts.forPhase(phase)
+	for _, h := range hooks {
+		result, err := runHook(ctx, client, kubeClient, recorder, h, timeout)
+		if err != nil {
+			return false, &hookError{hookName: h.name, reason: err.Error()}
+		}
+		if result != hookRunComplete {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// runHooksToCompletion drives runHooks to an actual end result by polling
+// it. Unlike sync(), which must never block its single worker, this is for
+// callers such as the operator's uninstall path that run once, outside the
+// controller's reconcile loop, and are expected to block until the hooks
+// finish or the phase's timeout elapses.
+func runHooksToCompletion(ctx context.Context, client operatorclient.OperatorClient, kubeClient kubernetes.Interface, recorder events.Recorder, phase hookPhase, timeouts hookTimeouts) error {
+	return wait.PollUntilContextTimeout(ctx, hookPollInterval, timeouts.forPhase(phase), true, func(ctx context.Context) (bool, error) {
+		return runHooks(ctx, client, kubeClient, recorder, phase, timeouts)
+	})
+}
+
+type hookObject struct {
+	name         string
+	weight       int
+	deletePolicy string
+	job          *batchv1.Job
+	configMap    *corev1.ConfigMap
+}
+
+// loadHooksForPhase decodes every baked asset under hookAssetPrefix,
+// keeps the ones annotated for phase and returns them sorted by
+// hook-weight ascending, the same order Helm applies its hooks in.
+func loadHooksForPhase(phase hookPhase) ([]hookObject, error) {
+	var hooks []hookObject
+	for _, name := range generated.AssetNames() {
+		if len(name) <= len(hookAssetPrefix) || name[:len(hookAssetPrefix)] != hookAssetPrefix {
+			continue
+		}
+
+		raw := generated.MustAsset(name)
+		h, matches, err := decodeHookAsset(name, raw, phase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode hook asset %s: %w", name, err)
+		}
+		if matches {
+			hooks = append(hooks, h)
+		}
+	}
+
+	sortHooksByWeight(hooks)
+	return hooks, nil
+}
+
+// sortHooksByWeight orders hooks ascending by hook-weight, the same order
+// Helm applies its own lifecycle hooks in. Hooks that share a weight keep
+// their relative asset order.
+func sortHooksByWeight(hooks []hookObject) {
+	sort.SliceStable(hooks, func(i, j int) bool { return hooks[i].weight < hooks[j].weight })
+}
+
+// decodeHookAsset decodes a hook asset according to its "kind:" field.
+// Jobs are the only hook kind that can be waited on; ConfigMaps are
+// applied but not polled for completion. Any other kind is rejected.
+func decodeHookAsset(name string, raw []byte, phase hookPhase) (hookObject, bool, error) {
+	switch assetKind(raw) {
+	case "Job":
+		job := resourceread.ReadJobV1OrDie(raw)
+		if !hookMatches(job.Annotations, phase) {
+			return hookObject{}, false, nil
+		}
+		return hookObject{
+			name:         name,
+			weight:       hookWeight(job.Annotations),
+			deletePolicy: job.Annotations[hookDeletePolicyAnnotation],
+			job:          job,
+		}, true, nil
+	case "ConfigMap":
+		cm := resourceread.ReadConfigMapV1OrDie(raw)
+		if !hookMatches(cm.Annotations, phase) {
+			return hookObject{}, false, nil
+		}
+		return hookObject{
+			name:         name,
+			weight:       hookWeight(cm.Annotations),
+			deletePolicy: cm.Annotations[hookDeletePolicyAnnotation],
+			configMap:    cm,
+		}, true, nil
+	default:
+		return hookObject{}, false, fmt.Errorf("asset has unsupported kind for a lifecycle hook")
+	}
+}
+
+// assetKind returns the value of the YAML "kind:" field. Lifecycle hook
+// assets are plain static YAML (no templating), so a line scan is enough
+// and avoids pulling in a generic YAML-to-unstructured decoder just for this.
+func assetKind(raw []byte) string {
+	for _, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if kind, ok := strings.CutPrefix(line, "kind:"); ok {
+			return strings.TrimSpace(kind)
+		}
+	}
+	return ""
+}
+
+func hookMatches(annotations map[string]string, phase hookPhase) bool {
+	return annotations[hookAnnotation] == string(phase)
+}
+
+func hookWeight(annotations map[string]string) int {
+	v, ok := annotations[hookWeightAnnotation]
+	if !ok {
+		return 0
+	}
+	weight, err := strconv.Atoi(v)
+	if err != nil {
+		return 0
+	}
+	return weight
+}
+
+// hookRunResult reports how far a single hook object got on this check,
+// since a Job hook may still be running after any number of calls.
+type hookRunResult int
+
+const (
+	// hookRunPending means a Job hook was applied (or already existed) and
+	// has neither completed nor failed yet.
+	hookRunPending hookRunResult = iota
+	// hookRunComplete means the hook object is fully done: a ConfigMap was
+	// applied, or a Job reported Complete.
+	hookRunComplete
+)
+
+// runHook applies a single hook object if needed and reports whether it has
+// finished, without blocking on a Job that is still running. Honors its
+// hook-delete-policy for any pre-existing, running or just-finished Job. A
+// Job already running from an earlier call is left alone rather than being
+// deleted and recreated, which would otherwise keep it from ever reaching
+// completion.
+func runHook(ctx context.Context, client operatorclient.OperatorClient, kubeClient kubernetes.Interface, recorder events.Recorder, h hookObject, timeout time.Duration) (hookRunResult, error) {
+	if h.configMap != nil {
+		_, _, err := resourceapply.ApplyConfigMap(kubeClient.CoreV1(), recorder, h.configMap)
+		if err != nil {
+			return hookRunPending, err
+		}
+		return hookRunComplete, nil
+	}
+
+	if h.job == nil {
+		return hookRunPending, fmt.Errorf("hook %s has no applicable object", h.name)
+	}
+
+	existing, err := kubeClient.BatchV1().Jobs(h.job.Namespace).Get(ctx, h.job.Name, metav1.GetOptions{})
+	switch {
+	case err == nil && jobRunning(existing):
+		return checkJobStatus(ctx, client, kubeClient, existing, h, timeout)
+	case err != nil && !apierrors.IsNotFound(err):
+		return hookRunPending, err
+	}
+
+	if apierrors.IsNotFound(err) && h.deletePolicy == hookDeletePolicySucceeded {
+		// The Job itself is gone, but that is also what deleting it on
+		// success looks like: check OperatorStatus for the marker left
+		// behind by an earlier completed run before assuming this hook has
+		// never executed and recreating (and re-running) its Job.
+		completed, statusErr := hookCompleted(client, h)
+		if statusErr != nil {
+			return hookRunPending, statusErr
+		}
+		if completed {
+			return hookRunComplete, nil
+		}
+	}
+
+	// Either there is no Job yet, or the previous run's Job already reached
+	// a terminal state: start a fresh run.
+	if err == nil && h.deletePolicy == hookDeletePolicyBeforeCreation {
+		if err := deleteJob(ctx, kubeClient, existing); err != nil {
+			return hookRunPending, err
+		}
+	}
+
+	job, _, err := resourceapply.ApplyJob(kubeClient.BatchV1(), recorder, h.job)
+	if err != nil {
+		return hookRunPending, err
+	}
+	return checkJobStatus(ctx, client, kubeClient, job, h, timeout)
+}
+
+// jobRunning reports whether job has neither completed nor failed yet.
+func jobRunning(job *batchv1.Job) bool {
+	complete, failed := jobConditionStatus(job)
+	return !complete && !failed
+}
+
+func jobConditionStatus(job *batchv1.Job) (complete, failed bool) {
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			complete = true
+		case batchv1.JobFailed:
+			failed = true
+		}
+	}
+	return complete, failed
+}
+
+// checkJobStatus looks at job's current status once, applying the
+// hook-delete-policy if it just reached a terminal state. It does not poll:
+// a Job still running past timeout is reported as an error.
+func checkJobStatus(ctx context.Context, client operatorclient.OperatorClient, kubeClient kubernetes.Interface, job *batchv1.Job, h hookObject, timeout time.Duration) (hookRunResult, error) {
+	complete, failed := jobConditionStatus(job)
+	switch {
+	case complete:
+		if h.deletePolicy == hookDeletePolicySucceeded {
+			// Persist that this hook has completed before the Job that is
+			// the only other record of that fact gets deleted, or the next
+			// sync() would see no Job, no marker, and re-run it.
+			if _, _, err := v1helpers.UpdateStatus(client, v1helpers.UpdateConditionFn(hookCompletedCondition(h))); err != nil {
+				return hookRunPending, err
+			}
+			_ = deleteJob(ctx, kubeClient, job)
+		}
+		return hookRunComplete, nil
+	case failed:
+		if h.deletePolicy == hookDeletePolicyFailed {
+			_ = deleteJob(ctx, kubeClient, job)
+		}
+		return hookRunPending, fmt.Errorf("job %s/%s failed", job.Namespace, job.Name)
+	default:
+		if time.Since(job.CreationTimestamp.Time) > timeout {
+			return hookRunPending, fmt.Errorf("job %s/%s did not reach completion within %s", job.Namespace, job.Name, timeout)
+		}
+		return hookRunPending, nil
+	}
+}
+
+// hookCompletedConditionType builds the OperatorStatus condition type used to
+// remember that a given hook Job has already run to completion, independent
+// of whether the Job object itself still exists.
+func hookCompletedConditionType(h hookObject) string {
+	return WebhookControllerName + "Hook" + sanitizeHookName(h.name) + "Completed"
+}
+
+// hookCompletedCondition builds the condition persisted once a Job hook with
+// hook-delete-policy: hook-succeeded reaches completion, so later reconciles
+// can tell it already ran even after its Job is deleted.
+func hookCompletedCondition(h hookObject) operatorapi.OperatorCondition {
+	return operatorapi.OperatorCondition{
+		Type:   hookCompletedConditionType(h),
+		Status: operatorapi.ConditionTrue,
+		Reason: "HookSucceeded",
+	}
+}
+
+// hookCompleted reports whether h was already recorded as completed by an
+// earlier call to hookCompletedCondition.
+func hookCompleted(client operatorclient.OperatorClient, h hookObject) (bool, error) {
+	_, opStatus, _, err := client.GetOperatorState()
+	if err != nil {
+		return false, err
+	}
+	condType := hookCompletedConditionType(h)
+	for _, cond := range opStatus.Conditions {
+		if cond.Type == condType {
+			return cond.Status == operatorapi.ConditionTrue, nil
+		}
+	}
+	return false, nil
+}
+
+// sanitizeHookName turns a baked hook asset path into a CamelCase token
+// suitable for embedding in an OperatorCondition Type, e.g.
+// "lifecycle-hooks/migrate-job.yaml" becomes "MigrateJob".
+func sanitizeHookName(name string) string {
+	name = strings.TrimPrefix(name, hookAssetPrefix)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	var b strings.Builder
+	upperNext := true
+	for _, r := range name {
+		if r == '-' || r == '_' || r == '/' || r == '.' {
+			upperNext = true
+			continue
+		}
+		if upperNext {
+			b.WriteRune(unicode.ToUpper(r))
+			upperNext = false
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func deleteJob(ctx context.Context, kubeClient kubernetes.Interface, job *batchv1.Job) error {
+	background := metav1.DeletePropagationBackground
+	err := kubeClient.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{
+		PropagationPolicy: &background,
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// hookTimeoutsFromSpec parses the hook timeout overrides, reusing the same
+// unsupportedConfigOverrides section as the other unsupported knobs.
+func hookTimeoutsFromSpec(opSpec *operatorapi.OperatorSpec) (hookTimeouts, error) {
+	if len(opSpec.UnsupportedConfigOverrides.Raw) == 0 {
+		return hookTimeouts{}, nil
+	}
+
+	var overrides struct {
+		Hooks hookTimeouts `json:"hooks"`
+	}
+	if err := json.Unmarshal(opSpec.UnsupportedConfigOverrides.Raw, &overrides); err != nil {
+		return hookTimeouts{}, err
+	}
+	return overrides.Hooks, nil
+}
+
+// hooksDegradedCondition builds the condition sync() should surface when a
+// lifecycle hook fails, naming the failing hook so operators don't have to
+// dig through events to find which Job broke the rollout.
+func hooksDegradedCondition(err error) operatorapi.OperatorCondition {
+	cond := operatorapi.OperatorCondition{
+		Type:   WebhookControllerName + "HooksDegraded",
+		Status: operatorapi.ConditionFalse,
+	}
+	if err == nil {
+		return cond
+	}
+
+	cond.Status = operatorapi.ConditionTrue
+	if hookErr, ok := err.(*hookError); ok {
+		cond.Reason = hookErr.hookName
+		cond.Message = hookErr.reason
+	} else {
+		cond.Reason = "HookError"
+		cond.Message = err.Error()
+	}
+	return cond
+}
+
+// hooksProgressingCondition builds the condition sync() should surface while
+// waiting on a still-running hook Job, so the Progressing condition names
+// the phase instead of just going quiet for however long the Job takes.
+func hooksProgressingCondition(phase hookPhase) operatorapi.OperatorCondition {
+	return operatorapi.OperatorCondition{
+		Type:    WebhookControllerName + operatorapi.OperatorStatusTypeProgressing,
+		Status:  operatorapi.ConditionTrue,
+		Reason:  "HooksPending",
+		Message: fmt.Sprintf("waiting for %s hooks to complete", phase),
+	}
+}
+
+// RunPreDeleteHooks runs the pre-delete lifecycle hooks to completion. It is
+// exported for the operator's uninstall path to call directly, and is called
+// from csiSnapshotWebhookController.syncRemoved before any managed resource
+// is deleted, since pre-delete hooks are expected to run while the
+// Deployment and webhook configurations are still in place. Unlike the hook
+// checks performed during normal reconciliation, it blocks until the phase
+// finishes or times out, since there either is no running controller to
+// re-enqueue on (the standalone uninstall path) or removal is a one-shot
+// operation with no further Managed-state work to make progress on in the
+// meantime.
+func RunPreDeleteHooks(ctx context.Context, client operatorclient.OperatorClient, kubeClient kubernetes.Interface, recorder events.Recorder, opSpec *operatorapi.OperatorSpec) error {
+	timeouts, err := hookTimeoutsFromSpec(opSpec)
+	if err != nil {
+		return err
+	}
+	return runHooksToCompletion(ctx, client, kubeClient, recorder, hookPreDelete, timeouts)
+}
+
+// RunPostDeleteHooks runs the post-delete lifecycle hooks to completion. It
+// mirrors RunPreDeleteHooks but is called from syncRemoved only after the
+// Deployment and webhook configurations have actually been deleted, since
+// post-delete hooks exist to clean up after those resources are gone.
+func RunPostDeleteHooks(ctx context.Context, client operatorclient.OperatorClient, kubeClient kubernetes.Interface, recorder events.Recorder, opSpec *operatorapi.OperatorSpec) error {
+	timeouts, err := hookTimeoutsFromSpec(opSpec)
+	if err != nil {
+		return err
+	}
+	return runHooksToCompletion(ctx, client, kubeClient, recorder, hookPostDelete, timeouts)
+}
@@ -0,0 +1,82 @@
+package webhookdeployment
+
+import "testing"
+
+func TestSortHooksByWeight(t *testing.T) {
+	hooks := []hookObject{
+		{name: "c", weight: 5},
+		{name: "a", weight: -10},
+		{name: "b", weight: -10},
+		{name: "d", weight: 0},
+	}
+
+	sortHooksByWeight(hooks)
+
+	want := []string{"a", "b", "d", "c"}
+	for i, name := range want {
+		if hooks[i].name != name {
+			t.Fatalf("position %d: expected %q, got %q (%v)", i, name, hooks[i].name, hooks)
+		}
+	}
+}
+
+func TestHookWeight(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        int
+	}{
+		{name: "no annotation defaults to zero", annotations: nil, want: 0},
+		{name: "valid weight", annotations: map[string]string{hookWeightAnnotation: "-5"}, want: -5},
+		{name: "non-numeric weight defaults to zero", annotations: map[string]string{hookWeightAnnotation: "bogus"}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hookWeight(tt.annotations); got != tt.want {
+				t.Errorf("expected weight %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHookMatches(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		phase       hookPhase
+		want        bool
+	}{
+		{name: "matching phase", annotations: map[string]string{hookAnnotation: string(hookPreInstall)}, phase: hookPreInstall, want: true},
+		{name: "different phase", annotations: map[string]string{hookAnnotation: string(hookPreInstall)}, phase: hookPostInstall, want: false},
+		{name: "no annotation", annotations: nil, phase: hookPreInstall, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hookMatches(tt.annotations, tt.phase); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAssetKind(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{name: "job", raw: "apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: foo\n", want: "Job"},
+		{name: "configmap", raw: "apiVersion: v1\nkind: ConfigMap\n", want: "ConfigMap"},
+		{name: "no kind", raw: "apiVersion: v1\n", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := assetKind([]byte(tt.raw)); got != tt.want {
+				t.Errorf("expected kind %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package webhookdeployment
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	admissionreviewv1 "k8s.io/api/admission/v1"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
+)
+
+var healthCheckTypeMeta = metav1.TypeMeta{
+	Kind:       "AdmissionReview",
+	APIVersion: "admission.k8s.io/v1",
+}
+
+// Reasons surfaced on the Progressing condition while the webhook rollout is
+// being gated on real readiness instead of just the Deployment's replica
+// count, so operators can tell which step of the rollout is stuck.
+const (
+	ReasonDeploying               = "Deploying"
+	ReasonEndpointsNotReady       = "EndpointsNotReady"
+	ReasonTLSHandshakeFailed      = "TLSHandshakeFailed"
+	ReasonAdmissionReviewRejected = "AdmissionReviewRejected"
+)
+
+const webhookHealthCheckTimeout = 5 * time.Second
+
+// waitForWebhookReady decides whether the webhook Deployment just applied is
+// actually serving traffic, not merely that the Deployment object looks
+// settled. It returns ready=true only once the rollout has finished, at
+// least one endpoint is Ready, and a health-check AdmissionReview against
+// that endpoint succeeds; otherwise it returns a reason/message pair meant
+// for the Progressing condition.
+func waitForWebhookReady(deployment *appsv1.Deployment, endpointsLister corelistersv1.EndpointsLister, webhookConfig *admissionv1.ValidatingWebhookConfiguration) (ready bool, reason, message string, err error) {
+	if ready, reason, message := deploymentRolledOut(deployment); !ready {
+		return false, reason, message, nil
+	}
+
+	if len(webhookConfig.Webhooks) == 0 || webhookConfig.Webhooks[0].ClientConfig.Service == nil {
+		return false, ReasonEndpointsNotReady, "webhook config has no Service reference", nil
+	}
+	svc := webhookConfig.Webhooks[0].ClientConfig.Service
+
+	ready, message = serviceHasReadyEndpoint(endpointsLister, svc.Namespace, svc.Name)
+	if !ready {
+		return false, ReasonEndpointsNotReady, message, nil
+	}
+
+	if err := healthCheckWebhook(webhookConfig.Webhooks[0].ClientConfig.CABundle, svc); err != nil {
+		if _, ok := err.(*admissionReviewRejectedError); ok {
+			return false, ReasonAdmissionReviewRejected, err.Error(), nil
+		}
+		return false, ReasonTLSHandshakeFailed, err.Error(), nil
+	}
+
+	return true, "", "", nil
+}
+
+func deploymentRolledOut(deployment *appsv1.Deployment) (bool, string, string) {
+	if deployment.Status.ObservedGeneration != deployment.Generation {
+		msg := fmt.Sprintf("desired generation %d, current generation %d", deployment.Generation, deployment.Status.ObservedGeneration)
+		return false, ReasonDeploying, msg
+	}
+	if deployment.Spec.Replicas == nil || deployment.Status.UpdatedReplicas != *deployment.Spec.Replicas {
+		msg := fmt.Sprintf("%d out of %d pods running", deployment.Status.UpdatedReplicas, int32Value(deployment.Spec.Replicas))
+		return false, ReasonDeploying, msg
+	}
+	return true, "", ""
+}
+
+func int32Value(v *int32) int32 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+func serviceHasReadyEndpoint(endpointsLister corelistersv1.EndpointsLister, namespace, name string) (bool, string) {
+	endpoints, err := endpointsLister.Endpoints(namespace).Get(name)
+	if err != nil {
+		return false, fmt.Sprintf("endpoints for service %s/%s not found: %v", namespace, name, err)
+	}
+
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) > 0 {
+			return true, ""
+		}
+	}
+	return false, fmt.Sprintf("service %s/%s has no Ready endpoints yet", namespace, name)
+}
+
+type admissionReviewRejectedError struct {
+	msg string
+}
+
+func (e *admissionReviewRejectedError) Error() string {
+	return e.msg
+}
+
+// healthCheckWebhook dials the webhook Service over TLS using the CA bundle
+// projected into the ValidatingWebhookConfiguration and posts a no-op
+// AdmissionReview, mirroring how the API server itself will call the
+// webhook. A rejected or malformed response means the webhook binary is up
+// but not correctly wired, which is distinct from a network/TLS failure.
+func healthCheckWebhook(caBundle []byte, svc *admissionv1.ServiceReference) error {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBundle) {
+		return fmt.Errorf("webhook CABundle does not contain a valid PEM certificate")
+	}
+
+	client := &http.Client{
+		Timeout: webhookHealthCheckTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	path := "/"
+	if svc.Path != nil {
+		path = *svc.Path
+	}
+	port := int32(443)
+	if svc.Port != nil {
+		port = *svc.Port
+	}
+	url := fmt.Sprintf("https://%s.%s.svc:%d%s", svc.Name, svc.Namespace, port, path)
+
+	body, err := json.Marshal(&admissionreviewv1.AdmissionReview{
+		TypeMeta: healthCheckTypeMeta,
+		Request:  &admissionreviewv1.AdmissionRequest{UID: "webhook-readiness-probe"},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &admissionReviewRejectedError{msg: fmt.Sprintf("webhook health check returned status %d", resp.StatusCode)}
+	}
+
+	var review admissionreviewv1.AdmissionReview
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		return &admissionReviewRejectedError{msg: fmt.Sprintf("webhook health check returned an unparsable AdmissionReview: %v", err)}
+	}
+	if review.Response == nil {
+		return &admissionReviewRejectedError{msg: "webhook health check response had no AdmissionResponse"}
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package webhookdeployment
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
+)
+
+func newEndpointsLister(endpoints ...*corev1.Endpoints) corelistersv1.EndpointsLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, ep := range endpoints {
+		indexer.Add(ep)
+	}
+	return corelistersv1.NewEndpointsLister(indexer)
+}
+
+func TestDeploymentRolledOut(t *testing.T) {
+	replicas := int32(2)
+
+	tests := []struct {
+		name       string
+		deployment *appsv1.Deployment
+		wantReady  bool
+		wantReason string
+	}{
+		{
+			name: "rolled out",
+			deployment: &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 1},
+				Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+				Status:     appsv1.DeploymentStatus{ObservedGeneration: 1, UpdatedReplicas: 2},
+			},
+			wantReady: true,
+		},
+		{
+			name: "observed generation stale",
+			deployment: &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 2},
+				Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+				Status:     appsv1.DeploymentStatus{ObservedGeneration: 1, UpdatedReplicas: 2},
+			},
+			wantReason: ReasonDeploying,
+		},
+		{
+			name: "not all replicas updated",
+			deployment: &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 1},
+				Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+				Status:     appsv1.DeploymentStatus{ObservedGeneration: 1, UpdatedReplicas: 1},
+			},
+			wantReason: ReasonDeploying,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready, reason, _ := deploymentRolledOut(tt.deployment)
+			if ready != tt.wantReady {
+				t.Errorf("expected ready=%v, got %v", tt.wantReady, ready)
+			}
+			if !ready && reason != tt.wantReason {
+				t.Errorf("expected reason %q, got %q", tt.wantReason, reason)
+			}
+		})
+	}
+}
+
+func TestServiceHasReadyEndpoint(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoints []*corev1.Endpoints
+		namespace string
+		svcName   string
+		wantReady bool
+	}{
+		{
+			name: "has ready address",
+			endpoints: []*corev1.Endpoints{{
+				ObjectMeta: metav1.ObjectMeta{Name: "webhook", Namespace: "ns"},
+				Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}},
+			}},
+			namespace: "ns",
+			svcName:   "webhook",
+			wantReady: true,
+		},
+		{
+			name: "only not-ready addresses",
+			endpoints: []*corev1.Endpoints{{
+				ObjectMeta: metav1.ObjectMeta{Name: "webhook", Namespace: "ns"},
+				Subsets:    []corev1.EndpointSubset{{NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}},
+			}},
+			namespace: "ns",
+			svcName:   "webhook",
+			wantReady: false,
+		},
+		{
+			name:      "endpoints not found",
+			namespace: "ns",
+			svcName:   "webhook",
+			wantReady: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lister := newEndpointsLister(tt.endpoints...)
+			ready, _ := serviceHasReadyEndpoint(lister, tt.namespace, tt.svcName)
+			if ready != tt.wantReady {
+				t.Errorf("expected ready=%v, got %v", tt.wantReady, ready)
+			}
+		})
+	}
+}
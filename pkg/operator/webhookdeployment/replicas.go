@@ -0,0 +1,120 @@
+package webhookdeployment
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	operatorapi "github.com/openshift/api/operator/v1"
+)
+
+const (
+	// defaultReplicaTarget is used when the operator CR does not override it.
+	defaultReplicaTarget = 2
+
+	hostnameTopologyKey = "kubernetes.io/hostname"
+	zoneTopologyKey     = "topology.kubernetes.io/zone"
+)
+
+// replicaTarget returns the number of replicas the operator CR asks for,
+// defaulting to defaultReplicaTarget when no override is set.
+func replicaTarget(opSpec *operatorapi.OperatorSpec) (int32, error) {
+	overrides, err := webhookOverridesFromSpec(opSpec)
+	if err != nil {
+		return 0, err
+	}
+	if overrides.Replicas != nil {
+		return *overrides.Replicas, nil
+	}
+	return defaultReplicaTarget, nil
+}
+
+// schedulableReadyNodeCount counts the nodes in nodes that are both Ready and
+// schedulable, i.e. the nodes the webhook Deployment could actually land its
+// pods on. Nodes that are cordoned or not yet Ready must not count towards
+// the replica cap or a Deployment can get stuck trying to schedule pods that
+// have nowhere to run.
+func schedulableReadyNodeCount(nodes []*corev1.Node) int32 {
+	var count int32
+	for _, node := range nodes {
+		if node.Spec.Unschedulable {
+			continue
+		}
+		if !isNodeReady(node) {
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// addTopologySpread spreads the webhook pods across hosts and zones so a
+// single node or zone failure during an upgrade cannot take down every
+// replica at once.
+func addTopologySpread(deployment *appsv1.Deployment) {
+	selector := deployment.Spec.Selector
+
+	deployment.Spec.Template.Spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{
+		{
+			MaxSkew:           1,
+			TopologyKey:       hostnameTopologyKey,
+			WhenUnsatisfiable: corev1.ScheduleAnyway,
+			LabelSelector:     selector,
+		},
+		{
+			MaxSkew:           1,
+			TopologyKey:       zoneTopologyKey,
+			WhenUnsatisfiable: corev1.ScheduleAnyway,
+			LabelSelector:     selector,
+		},
+	}
+
+	deployment.Spec.Template.Spec.Affinity = &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+				{
+					Weight: 100,
+					PodAffinityTerm: corev1.PodAffinityTerm{
+						LabelSelector: selector,
+						TopologyKey:   hostnameTopologyKey,
+					},
+				},
+				{
+					Weight: 50,
+					PodAffinityTerm: corev1.PodAffinityTerm{
+						LabelSelector: selector,
+						TopologyKey:   zoneTopologyKey,
+					},
+				},
+			},
+		},
+	}
+}
+
+// podDisruptionBudgetFor builds the PodDisruptionBudget protecting the
+// webhook Deployment. It is only meaningful once there is more than one
+// replica to spread disruptions across.
+func podDisruptionBudgetFor(deployment *appsv1.Deployment) *policyv1.PodDisruptionBudget {
+	minAvailable := intstr.FromInt(1)
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      deployment.Name,
+			Namespace: deployment.Namespace,
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MinAvailable: &minAvailable,
+			Selector:     deployment.Spec.Selector,
+		},
+	}
+}
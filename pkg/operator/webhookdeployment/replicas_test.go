@@ -0,0 +1,83 @@
+package webhookdeployment
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	operatorapi "github.com/openshift/api/operator/v1"
+)
+
+func readyNode(name string, unschedulable bool) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.NodeSpec{Unschedulable: unschedulable},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+}
+
+func notReadyNode(name string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionFalse},
+			},
+		},
+	}
+}
+
+func TestSchedulableReadyNodeCount(t *testing.T) {
+	nodes := []*corev1.Node{
+		readyNode("a", false),
+		readyNode("b", true),
+		notReadyNode("c"),
+		readyNode("d", false),
+	}
+
+	if got := schedulableReadyNodeCount(nodes); got != 2 {
+		t.Errorf("expected 2 schedulable ready nodes, got %d", got)
+	}
+}
+
+func TestReplicaTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    int32
+		wantErr bool
+	}{
+		{name: "no override defaults", want: defaultReplicaTarget},
+		{name: "override applied", raw: `{"webhook":{"replicas":5}}`, want: 5},
+		{name: "malformed json", raw: `{`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &operatorapi.OperatorSpec{}
+			if tt.raw != "" {
+				spec.UnsupportedConfigOverrides = runtime.RawExtension{Raw: []byte(tt.raw)}
+			}
+
+			got, err := replicaTarget(spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
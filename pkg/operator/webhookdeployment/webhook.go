@@ -18,7 +18,9 @@ import (
 	"github.com/openshift/library-go/pkg/operator/v1helpers"
 	admissionv1 "k8s.io/api/admissionregistration/v1"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
@@ -36,6 +38,10 @@ type csiSnapshotWebhookController struct {
 	nodeLister    corelistersv1.NodeLister
 	eventRecorder events.Recorder
 
+	certManager     *webhookCertManager
+	endpointsLister corelistersv1.EndpointsLister
+	targetNamespace string
+
 	queue workqueue.RateLimitingInterface
 
 	csiSnapshotWebhookImage string
@@ -46,6 +52,7 @@ const (
 	webhookVersionName    = "CSISnapshotWebhookDeployment"
 	deploymentAsset       = "webhook_deployment.yaml"
 	webhookAsset          = "webhook_config.yaml"
+	mutatingWebhookAsset  = "webhook_mutating_config.yaml"
 )
 
 var (
@@ -54,7 +61,7 @@ var (
 )
 
 func init() {
-	// Register admission/v1 schema for ValidatingWebhookConfiguration decoding
+	// Register admission/v1 schema for (Validating|Mutating)WebhookConfiguration decoding
 	if err := admissionv1.AddToScheme(admissionScheme); err != nil {
 		panic(err)
 	}
@@ -66,15 +73,22 @@ func NewCSISnapshotWebhookController(
 	nodeInformer coreinformersv1.NodeInformer,
 	deployInformer appsinformersv1.DeploymentInformer,
 	webhookInformer admissionnformersv1.ValidatingWebhookConfigurationInformer,
+	mutatingWebhookInformer admissionnformersv1.MutatingWebhookConfigurationInformer,
+	secretInformer coreinformersv1.SecretInformer,
+	endpointsInformer coreinformersv1.EndpointsInformer,
 	kubeClient kubernetes.Interface,
 	eventRecorder events.Recorder,
 	csiSnapshotWebhookImage string,
+	targetNamespace string,
 ) factory.Controller {
 	c := &csiSnapshotWebhookController{
 		client:                  client,
 		kubeClient:              kubeClient,
 		nodeLister:              nodeInformer.Lister(),
 		eventRecorder:           eventRecorder,
+		certManager:             newWebhookCertManager(kubeClient, secretInformer.Lister(), eventRecorder, targetNamespace),
+		endpointsLister:         endpointsInformer.Lister(),
+		targetNamespace:         targetNamespace,
 		queue:                   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "csi-snapshot-controller"),
 		csiSnapshotWebhookImage: csiSnapshotWebhookImage,
 	}
@@ -84,6 +98,9 @@ func NewCSISnapshotWebhookController(
 		nodeInformer.Informer(),
 		deployInformer.Informer(),
 		webhookInformer.Informer(),
+		mutatingWebhookInformer.Informer(),
+		secretInformer.Informer(),
+		endpointsInformer.Informer(),
 	).ToController(WebhookControllerName, eventRecorder.WithComponentSuffix(WebhookControllerName))
 }
 
@@ -95,17 +112,58 @@ func (c *csiSnapshotWebhookController) sync(ctx context.Context, syncCtx factory
 		}
 		return err
 	}
+	if opSpec.ManagementState == operatorapi.Removed {
+		return c.syncRemoved(ctx, syncCtx, opSpec)
+	}
 	if opSpec.ManagementState != operatorapi.Managed {
 		return nil
 	}
 
+	caBundle, certsRotated, err := c.certManager.ensureCerts()
+	if err != nil {
+		// This will set Degraded condition
+		return err
+	}
+	if certsRotated {
+		// The serving cert or its CA were just (re)generated: the Deployment
+		// pods need to pick up the new Secret and the webhook config needs
+		// the new CABundle. Re-enqueue so that doesn't wait for the next
+		// resync period.
+		syncCtx.Queue().Add(syncCtx.QueueKey())
+	}
+
+	hookTimeouts, err := hookTimeoutsFromSpec(opSpec)
+	if err != nil {
+		// This will set Degraded condition
+		return err
+	}
+	preInstallDone, preInstallErr := runHooks(ctx, c.client, c.kubeClient, syncCtx.Recorder(), hookPreInstall, hookTimeouts)
+	if preInstallErr != nil {
+		if _, _, err := v1helpers.UpdateStatus(c.client, v1helpers.UpdateConditionFn(hooksDegradedCondition(preInstallErr))); err != nil {
+			return err
+		}
+		return preInstallErr
+	}
+	if !preInstallDone {
+		// The pre-install hook Job(s) are still running: check again on a
+		// later reconcile instead of blocking this goroutine on them, same
+		// as the cert-rotation re-check above and the readiness re-check below.
+		if _, _, err := v1helpers.UpdateStatus(c.client, v1helpers.UpdateConditionFn(hooksProgressingCondition(hookPreInstall))); err != nil {
+			return err
+		}
+		c.queue.AddRateLimited(factory.DefaultQueueKey)
+		return nil
+	}
+
 	deployment, err := c.getDeployment(opSpec)
 	if err != nil {
 		// This will set Degraded condition
 		return err
 	}
 
-	// Set the number of replicas according to the number of nodes available
+	// Cap the requested replica target at the number of nodes that could
+	// actually run a webhook pod, so the Deployment never asks for more
+	// replicas than can schedule.
 	nodeSelector := deployment.Spec.Template.Spec.NodeSelector
 	nodes, err := c.nodeLister.List(labels.SelectorFromSet(nodeSelector))
 	if err != nil {
@@ -113,15 +171,24 @@ func (c *csiSnapshotWebhookController) sync(ctx context.Context, syncCtx factory
 		return err
 	}
 
-	// Set the deployment.Spec.Replicas field according to the number
-	// of available nodes. If the number of available nodes is bigger
-	// than 1, then the number of replicas will be 2.
-	replicas := int32(1)
-	if len(nodes) > 1 {
-		replicas = int32(2)
+	target, err := replicaTarget(opSpec)
+	if err != nil {
+		// This will set Degraded condition
+		return err
+	}
+	replicas := target
+	if available := schedulableReadyNodeCount(nodes); available < replicas {
+		replicas = available
+	}
+	if replicas < 1 {
+		replicas = 1
 	}
 	deployment.Spec.Replicas = &replicas
 
+	if replicas > 1 {
+		addTopologySpread(deployment)
+	}
+
 	lastGeneration := resourcemerge.ExpectedDeploymentGeneration(deployment, opStatus.Generations)
 	deployment, _, err = resourceapply.ApplyDeployment(c.kubeClient.AppsV1(), syncCtx.Recorder(), deployment, lastGeneration)
 	if err != nil {
@@ -129,8 +196,32 @@ func (c *csiSnapshotWebhookController) sync(ctx context.Context, syncCtx factory
 		return err
 	}
 
-	webhookConfig, err := getWebhookConfig()
+	if replicas > 1 {
+		pdb := podDisruptionBudgetFor(deployment)
+		if _, _, err := resourceapply.ApplyPodDisruptionBudget(c.kubeClient.PolicyV1(), syncCtx.Recorder(), pdb); err != nil {
+			// This will set Degraded condition
+			return err
+		}
+	} else {
+		// A PodDisruptionBudget left over from when replicas > 1 would block
+		// voluntary evictions (e.g. kubectl drain) on the single remaining
+		// pod forever, so it must go away once there's nothing left to
+		// spread disruptions across.
+		if err := c.deletePodDisruptionBudget(ctx, deployment.Name); err != nil {
+			// This will set Degraded condition
+			return err
+		}
+	}
+
+	overrides, err := webhookOverridesFromSpec(opSpec)
+	if err != nil {
+		// This will set Degraded condition, e.g. on malformed override JSON
+		return err
+	}
+
+	webhookConfig, err := getWebhookConfig(caBundle, overrides)
 	if err != nil {
+		// This will set Degraded condition, e.g. on an invalid label selector
 		return err
 	}
 	lastWebhookGeneration := resourcemerge.ExpectedValidatingWebhooksConfiguration(webhookConfig.Name, opStatus.Generations)
@@ -139,44 +230,87 @@ func (c *csiSnapshotWebhookController) sync(ctx context.Context, syncCtx factory
 		return err
 	}
 
-	// Compute status
-	// Available: at least one replica is running
-	deploymentAvailable := operatorapi.OperatorCondition{
-		Type: WebhookControllerName + operatorapi.OperatorStatusTypeAvailable,
+	disableMutating, err := mutatingWebhookDisabled(opSpec)
+	if err != nil {
+		return err
 	}
-	if deployment.Status.AvailableReplicas > 0 {
-		deploymentAvailable.Status = operatorapi.ConditionTrue
+	var mutatingWebhookConfig *admissionv1.MutatingWebhookConfiguration
+	if !disableMutating {
+		mutatingWebhookConfig, err = getMutatingWebhookConfig(caBundle)
+		if err != nil {
+			return err
+		}
+		lastMutatingWebhookGeneration := resourcemerge.ExpectedMutatingWebhooksConfiguration(mutatingWebhookConfig.Name, opStatus.Generations)
+		mutatingWebhookConfig, _, err = resourceapply.ApplyMutatingWebhookConfiguration(c.kubeClient.AdmissionregistrationV1(), syncCtx.Recorder(), mutatingWebhookConfig, lastMutatingWebhookGeneration)
+		if err != nil {
+			return err
+		}
 	} else {
-		deploymentAvailable.Status = operatorapi.ConditionFalse
-		deploymentAvailable.Reason = "Deploying"
-		deploymentAvailable.Message = "Waiting for a validating webhook Deployment pod to start"
+		// The admin explicitly asked for the defaulting/normalizing webhook
+		// to be turned off: a previously-applied MutatingWebhookConfiguration
+		// must not keep mutating objects behind their back.
+		if err := c.deleteMutatingWebhookConfig(ctx, syncCtx.Recorder()); err != nil {
+			return err
+		}
+	}
+
+	postInstallDone, postInstallErr := runHooks(ctx, c.client, c.kubeClient, syncCtx.Recorder(), hookPostInstall, hookTimeouts)
+	if postInstallErr != nil {
+		if _, _, err := v1helpers.UpdateStatus(c.client, v1helpers.UpdateConditionFn(hooksDegradedCondition(postInstallErr))); err != nil {
+			return err
+		}
+		return postInstallErr
+	}
+	if !postInstallDone {
+		if _, _, err := v1helpers.UpdateStatus(c.client, v1helpers.UpdateConditionFn(hooksProgressingCondition(hookPostInstall))); err != nil {
+			return err
+		}
+		c.queue.AddRateLimited(factory.DefaultQueueKey)
+		return nil
+	}
+	if _, _, err := v1helpers.UpdateStatus(c.client, v1helpers.UpdateConditionFn(hooksDegradedCondition(nil))); err != nil {
+		return err
+	}
+
+	// Compute status. The Deployment looking "settled" is not enough: gate
+	// Available on the webhook actually responding, so a broken rollout
+	// shows up as Progressing instead of a false Available=True.
+	webhookReady, notReadyReason, notReadyMessage, err := waitForWebhookReady(deployment, c.endpointsLister, webhookConfig)
+	if err != nil {
+		// This will set Degraded condition
+		return err
 	}
 
-	// Not progressing: all replicas are at the latest version && Deployment generation matches
+	deploymentAvailable := operatorapi.OperatorCondition{
+		Type: WebhookControllerName + operatorapi.OperatorStatusTypeAvailable,
+	}
 	deploymentProgressing := operatorapi.OperatorCondition{
 		Type: WebhookControllerName + operatorapi.OperatorStatusTypeProgressing,
 	}
-	if deployment.Status.ObservedGeneration != deployment.Generation {
-		deploymentProgressing.Status = operatorapi.ConditionTrue
-		deploymentProgressing.Reason = "Deploying"
-		msg := fmt.Sprintf("desired generation %d, current generation %d", deployment.Generation, deployment.Status.ObservedGeneration)
-		deploymentProgressing.Message = msg
+
+	if webhookReady {
+		deploymentAvailable.Status = operatorapi.ConditionTrue
+		deploymentProgressing.Status = operatorapi.ConditionFalse
 	} else {
-		if deployment.Spec.Replicas != nil {
-			if deployment.Status.UpdatedReplicas == *deployment.Spec.Replicas {
-				deploymentProgressing.Status = operatorapi.ConditionFalse
-			} else {
-				msg := fmt.Sprintf("%d out of %d pods running", deployment.Status.UpdatedReplicas, *deployment.Spec.Replicas)
-				deploymentProgressing.Status = operatorapi.ConditionTrue
-				deploymentProgressing.Reason = "Deploying"
-				deploymentProgressing.Message = msg
-			}
-		}
+		deploymentAvailable.Status = operatorapi.ConditionFalse
+		deploymentAvailable.Reason = notReadyReason
+		deploymentAvailable.Message = notReadyMessage
+
+		deploymentProgressing.Status = operatorapi.ConditionTrue
+		deploymentProgressing.Reason = notReadyReason
+		deploymentProgressing.Message = notReadyMessage
+
+		// Keep checking until the webhook is actually serving, with the
+		// rate limiter backing off exponentially between attempts.
+		c.queue.AddRateLimited(factory.DefaultQueueKey)
 	}
 
 	updateGenerationFn := func(newStatus *operatorapi.OperatorStatus) error {
 		resourcemerge.SetDeploymentGeneration(&newStatus.Generations, deployment)
 		resourcemerge.SetValidatingWebhooksConfigurationGeneration(&newStatus.Generations, webhookConfig)
+		if mutatingWebhookConfig != nil {
+			resourcemerge.SetMutatingWebhooksConfigurationGeneration(&newStatus.Generations, mutatingWebhookConfig)
+		}
 		return nil
 	}
 
@@ -188,6 +322,38 @@ func (c *csiSnapshotWebhookController) sync(ctx context.Context, syncCtx factory
 	return err
 }
 
+// syncRemoved tears down everything this controller manages when the CR
+// asks to be removed: it runs the pre-delete hooks, then deletes the
+// Deployment and webhook configurations, then runs the post-delete hooks,
+// so uninstalling the operator actually uninstalls the webhook instead of
+// leaving it running, and post-delete hooks can assume those resources are
+// already gone.
+func (c *csiSnapshotWebhookController) syncRemoved(ctx context.Context, syncCtx factory.SyncContext, opSpec *operatorapi.OperatorSpec) error {
+	if err := RunPreDeleteHooks(ctx, c.client, c.kubeClient, syncCtx.Recorder(), opSpec); err != nil {
+		return err
+	}
+
+	if err := c.deleteMutatingWebhookConfig(ctx, syncCtx.Recorder()); err != nil {
+		return err
+	}
+	if err := c.deleteValidatingWebhookConfig(ctx, syncCtx.Recorder()); err != nil {
+		return err
+	}
+
+	deployment, err := c.getDeployment(opSpec)
+	if err != nil {
+		return err
+	}
+	if err := c.deletePodDisruptionBudget(ctx, deployment.Name); err != nil {
+		return err
+	}
+	if err := c.deleteDeployment(ctx, deployment.Name); err != nil {
+		return err
+	}
+
+	return RunPostDeleteHooks(ctx, c.client, c.kubeClient, syncCtx.Recorder(), opSpec)
+}
+
 func (c *csiSnapshotWebhookController) getDeployment(opSpec *operatorapi.OperatorSpec) (*appsv1.Deployment, error) {
 	deploymentString := string(generated.MustAsset(deploymentAsset))
 
@@ -201,11 +367,81 @@ func (c *csiSnapshotWebhookController) getDeployment(opSpec *operatorapi.Operato
 	deploymentString = strings.ReplaceAll(deploymentString, "${LOG_LEVEL}", strconv.Itoa(logLevel))
 
 	deployment := resourceread.ReadDeploymentV1OrDie([]byte(deploymentString))
+	addServingCertVolume(deployment)
 	return deployment, nil
 
 }
 
-func getWebhookConfig() (*admissionv1.ValidatingWebhookConfiguration, error) {
+// addServingCertVolume mounts the serving certificate Secret managed by
+// webhookCertManager into the webhook container so the process can serve
+// TLS using a cert the operator itself generated and rotated.
+func addServingCertVolume(deployment *appsv1.Deployment) {
+	const volumeName = "webhook-serving-cert"
+
+	deployment.Spec.Template.Spec.Volumes = append(deployment.Spec.Template.Spec.Volumes, corev1.Volume{
+		Name: volumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: servingSecretName,
+			},
+		},
+	})
+
+	for i := range deployment.Spec.Template.Spec.Containers {
+		deployment.Spec.Template.Spec.Containers[i].VolumeMounts = append(
+			deployment.Spec.Template.Spec.Containers[i].VolumeMounts,
+			corev1.VolumeMount{
+				Name:      volumeName,
+				MountPath: "/etc/webhook/certs",
+				ReadOnly:  true,
+			},
+		)
+	}
+}
+
+// deleteDeployment removes the webhook Deployment. It is safe to call
+// whether or not the object currently exists.
+func (c *csiSnapshotWebhookController) deleteDeployment(ctx context.Context, name string) error {
+	err := c.kubeClient.AppsV1().Deployments(c.targetNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// deleteValidatingWebhookConfig removes the ValidatingWebhookConfiguration
+// this operator manages. It is safe to call whether or not the object
+// currently exists.
+func (c *csiSnapshotWebhookController) deleteValidatingWebhookConfig(ctx context.Context, recorder events.Recorder) error {
+	webhookBytes := generated.MustAsset(webhookAsset)
+	requiredObj, err := runtime.Decode(admissionCodecs.UniversalDecoder(admissionv1.SchemeGroupVersion), webhookBytes)
+	if err != nil {
+		return err
+	}
+	name := requiredObj.(*admissionv1.ValidatingWebhookConfiguration).Name
+
+	err = c.kubeClient.AdmissionregistrationV1().ValidatingWebhookConfigurations().Delete(ctx, name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err == nil {
+		recorder.Eventf("ValidatingWebhookConfigurationDeleted", "Deleted %s because the CR was removed", name)
+	}
+	return err
+}
+
+// deletePodDisruptionBudget removes the PodDisruptionBudget previously
+// created for the webhook Deployment, if any. It is safe to call whether or
+// not the object currently exists.
+func (c *csiSnapshotWebhookController) deletePodDisruptionBudget(ctx context.Context, name string) error {
+	err := c.kubeClient.PolicyV1().PodDisruptionBudgets(c.targetNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func getWebhookConfig(caBundle []byte, overrides webhookOverrides) (*admissionv1.ValidatingWebhookConfiguration, error) {
 	webhookBytes := generated.MustAsset(webhookAsset)
 	requiredObj, err := runtime.Decode(admissionCodecs.UniversalDecoder(admissionv1.SchemeGroupVersion), webhookBytes)
 	if err != nil {
@@ -213,7 +449,53 @@ func getWebhookConfig() (*admissionv1.ValidatingWebhookConfiguration, error) {
 	}
 
 	webhook := requiredObj.(*admissionv1.ValidatingWebhookConfiguration)
-	// Set hash of Webhooks[] to apply new ValidatingWebhookConfiguration when the asset changes on the operator update.
+	for i := range webhook.Webhooks {
+		webhook.Webhooks[i].ClientConfig.CABundle = caBundle
+	}
+	if err := applyWebhookOverrides(webhook.Webhooks, overrides); err != nil {
+		return nil, err
+	}
+	// Set hash of Webhooks[] to apply new ValidatingWebhookConfiguration when the asset, the CA bundle or the overrides change.
+	resourceapply.SetSpecHashAnnotation(&webhook.ObjectMeta, webhook.Webhooks)
+	return webhook, nil
+}
+
+// getMutatingWebhookConfig mirrors getWebhookConfig for the mutating side of
+// the webhook, which defaults VolumeSnapshotClass and normalizes source refs
+// on VolumeSnapshot/VolumeSnapshotContent.
+func getMutatingWebhookConfig(caBundle []byte) (*admissionv1.MutatingWebhookConfiguration, error) {
+	webhookBytes := generated.MustAsset(mutatingWebhookAsset)
+	requiredObj, err := runtime.Decode(admissionCodecs.UniversalDecoder(admissionv1.SchemeGroupVersion), webhookBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook := requiredObj.(*admissionv1.MutatingWebhookConfiguration)
+	for i := range webhook.Webhooks {
+		webhook.Webhooks[i].ClientConfig.CABundle = caBundle
+	}
+	// Set hash of Webhooks[] to apply new MutatingWebhookConfiguration when the asset (or the CA bundle) changes.
 	resourceapply.SetSpecHashAnnotation(&webhook.ObjectMeta, webhook.Webhooks)
 	return webhook, nil
 }
+
+// deleteMutatingWebhookConfig removes the MutatingWebhookConfiguration this
+// operator would otherwise manage. It is safe to call whether or not the
+// object currently exists.
+func (c *csiSnapshotWebhookController) deleteMutatingWebhookConfig(ctx context.Context, recorder events.Recorder) error {
+	webhookBytes := generated.MustAsset(mutatingWebhookAsset)
+	requiredObj, err := runtime.Decode(admissionCodecs.UniversalDecoder(admissionv1.SchemeGroupVersion), webhookBytes)
+	if err != nil {
+		return err
+	}
+	name := requiredObj.(*admissionv1.MutatingWebhookConfiguration).Name
+
+	err = c.kubeClient.AdmissionregistrationV1().MutatingWebhookConfigurations().Delete(ctx, name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err == nil {
+		recorder.Eventf("MutatingWebhookConfigurationDeleted", "Deleted %s because the mutating webhook was disabled or the CR was removed", name)
+	}
+	return err
+}